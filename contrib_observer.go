@@ -22,6 +22,7 @@ package jaeger
 
 import (
 	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
 )
 
 // ContribObserver can be registered with the Tracer to receive notifications
@@ -51,6 +52,41 @@ type ContribSpanObserver interface {
 	OnFinish(options opentracing.FinishOptions)
 }
 
+// The following interfaces extend ContribSpanObserver with additional span
+// lifecycle notifications. They are optional: a ContribSpanObserver may
+// implement any subset of them, and compositeSpanObserver fans each
+// notification out via type assertion, so observers that only implement
+// ContribSpanObserver keep working unmodified.
+
+// ContribSpanObserverLogFields is implemented by span observers that want to
+// be notified when log.Field entries are recorded on the span via
+// Span.LogFields. Span.LogKV (and the Log* methods built on it) notifies
+// ContribSpanObserverLogKV instead.
+type ContribSpanObserverLogFields interface {
+	OnLogFields(fields []log.Field)
+}
+
+// ContribSpanObserverLogKV is implemented by span observers that want to be
+// notified when key/value pairs are logged on the span, e.g. via
+// Span.LogKV.
+type ContribSpanObserverLogKV interface {
+	OnLogKV(alternatingKeys ...interface{})
+}
+
+// ContribSpanObserverBaggage is implemented by span observers that want to
+// be notified when a baggage item is set on the span, e.g. via
+// Span.SetBaggageItem.
+type ContribSpanObserverBaggage interface {
+	OnSetBaggageItem(key, value string)
+}
+
+// ContribSpanObserverReference is implemented by span observers that want
+// to be notified when a reference to another span (e.g. ChildOf or
+// FollowsFrom) is recorded on the span.
+type ContribSpanObserverReference interface {
+	OnAddReference(refType opentracing.SpanReferenceType, referencedContext SpanContext)
+}
+
 // wrapper observer for the old observers (see observer.go)
 type oldObserver struct {
 	obs Observer
@@ -112,3 +148,35 @@ func (o *compositeSpanObserver) OnFinish(options opentracing.FinishOptions) {
 		obs.OnFinish(options)
 	}
 }
+
+func (o *compositeSpanObserver) OnLogFields(fields []log.Field) {
+	for _, obs := range o.observers {
+		if spanObs, ok := obs.(ContribSpanObserverLogFields); ok {
+			spanObs.OnLogFields(fields)
+		}
+	}
+}
+
+func (o *compositeSpanObserver) OnLogKV(alternatingKeys ...interface{}) {
+	for _, obs := range o.observers {
+		if spanObs, ok := obs.(ContribSpanObserverLogKV); ok {
+			spanObs.OnLogKV(alternatingKeys...)
+		}
+	}
+}
+
+func (o *compositeSpanObserver) OnSetBaggageItem(key, value string) {
+	for _, obs := range o.observers {
+		if spanObs, ok := obs.(ContribSpanObserverBaggage); ok {
+			spanObs.OnSetBaggageItem(key, value)
+		}
+	}
+}
+
+func (o *compositeSpanObserver) OnAddReference(refType opentracing.SpanReferenceType, referencedContext SpanContext) {
+	for _, obs := range o.observers {
+		if spanObs, ok := obs.(ContribSpanObserverReference); ok {
+			spanObs.OnAddReference(refType, referencedContext)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright (c) 2019 The Jaeger Authors.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jaeger
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+
+	"github.com/uber/jaeger-client-go/metrics"
+)
+
+// errAsyncObserverClosed is returned by Flush once the AsyncObserver has
+// been closed.
+var errAsyncObserverClosed = errors.New("jaeger: async observer is closed")
+
+// flushPollInterval is how often Flush re-checks whether the queue has
+// drained. Flush has no channel it can select on to be woken exactly when
+// a resolve happens (resolves come from arbitrary worker goroutines and
+// from the eviction path inside enqueue), so it polls instead.
+const flushPollInterval = time.Millisecond
+
+// DropPolicy controls what an AsyncObserver does when its callback queue is
+// full.
+type DropPolicy int
+
+const (
+	// DropOldest discards the oldest queued callback to make room for the
+	// new one. This is the default.
+	DropOldest DropPolicy = iota
+
+	// DropNewest discards the callback that was about to be enqueued,
+	// leaving the queue untouched.
+	DropNewest
+
+	// Block waits for room in the queue instead of dropping anything. Use
+	// with care: this reintroduces backpressure onto the span hot path,
+	// which is the condition AsyncObserver exists to avoid.
+	Block
+)
+
+// AsyncOptions configures an AsyncObserver.
+type AsyncOptions struct {
+	// QueueSize bounds the number of pending callbacks. Defaults to
+	// defaultAsyncQueueSize.
+	QueueSize int
+
+	// Workers is the number of goroutines draining the callback queue.
+	// Defaults to defaultAsyncWorkers.
+	Workers int
+
+	// DropPolicy selects the behavior when the queue is full. Defaults to
+	// DropOldest.
+	DropPolicy DropPolicy
+
+	// Factory, if set, is used to report observer.queue.dropped,
+	// observer.queue.length and observer.callback.latency metrics.
+	Factory metrics.Factory
+}
+
+const (
+	defaultAsyncQueueSize = 1000
+	defaultAsyncWorkers   = 1
+)
+
+func (o AsyncOptions) withDefaults() AsyncOptions {
+	if o.QueueSize <= 0 {
+		o.QueueSize = defaultAsyncQueueSize
+	}
+	if o.Workers <= 0 {
+		o.Workers = defaultAsyncWorkers
+	}
+	if o.Factory == nil {
+		o.Factory = metrics.NullFactory
+	}
+	return o
+}
+
+// asyncMetrics are the metrics an AsyncObserver reports about its own queue.
+type asyncMetrics struct {
+	dropped         metrics.Counter
+	queueLength     metrics.Gauge
+	callbackLatency metrics.Timer
+}
+
+func newAsyncMetrics(factory metrics.Factory) *asyncMetrics {
+	return &asyncMetrics{
+		dropped:         factory.Counter("observer.queue.dropped", nil),
+		queueLength:     factory.Gauge("observer.queue.length", nil),
+		callbackLatency: factory.Timer(metrics.TimerOptions{Name: "observer.callback.latency"}),
+	}
+}
+
+// AsyncObserver wraps a ContribObserver so that its callbacks run on a
+// bounded worker pool instead of synchronously on the span hot path
+// (StartSpan, SetTag, LogFields, Finish, ...). A slow or blocked downstream
+// observer can no longer stall span operations; callbacks are dropped (with
+// a counter) once the queue is full, unless AsyncOptions.DropPolicy is set
+// to Block.
+type AsyncObserver struct {
+	delegate ContribObserver
+	options  AsyncOptions
+	metrics  *asyncMetrics
+
+	queue chan func()
+	wg    sync.WaitGroup
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	// enqueued and resolved together let Flush wait for "drained" without
+	// an in-band sentinel: enqueued counts every callback accepted onto
+	// the queue; resolved counts every callback that has since left it,
+	// whether by running (worker/drainQueue) or by eviction (DropOldest).
+	// Since every accepted callback is resolved exactly once, Flush only
+	// needs resolved to catch up to the enqueued count it observed.
+	enqueued uint64
+	resolved uint64
+}
+
+// WrapAsync wraps obs so that its ContribSpanObserver callbacks execute
+// asynchronously on a bounded worker pool.
+func WrapAsync(obs ContribObserver, opts AsyncOptions) ContribObserver {
+	opts = opts.withDefaults()
+	a := &AsyncObserver{
+		delegate: obs,
+		options:  opts,
+		metrics:  newAsyncMetrics(opts.Factory),
+		queue:    make(chan func(), opts.QueueSize),
+		closed:   make(chan struct{}),
+	}
+	for i := 0; i < opts.Workers; i++ {
+		a.wg.Add(1)
+		go a.worker()
+	}
+	return a
+}
+
+func (a *AsyncObserver) worker() {
+	defer a.wg.Done()
+	for {
+		select {
+		case cb := <-a.queue:
+			a.runCallback(cb)
+		case <-a.closed:
+			a.drainQueue()
+			return
+		}
+	}
+}
+
+// drainQueue runs every callback already sitting in the queue without
+// blocking, so Close doesn't discard work that was enqueued before it ran.
+func (a *AsyncObserver) drainQueue() {
+	for {
+		select {
+		case cb := <-a.queue:
+			a.runCallback(cb)
+		default:
+			return
+		}
+	}
+}
+
+func (a *AsyncObserver) runCallback(cb func()) {
+	start := time.Now()
+	cb()
+	a.metrics.callbackLatency.Record(time.Since(start))
+	atomic.AddUint64(&a.resolved, 1)
+}
+
+// enqueue schedules cb to run on the worker pool, applying the configured
+// DropPolicy if the queue is full. The queue channel is never closed (only
+// a.closed is), so enqueue only ever sends on an open channel; once closed,
+// it drops instead of sending, since no worker is left to drain it.
+func (a *AsyncObserver) enqueue(cb func()) {
+	select {
+	case <-a.closed:
+		a.metrics.dropped.Inc(1)
+		return
+	default:
+	}
+
+	a.metrics.queueLength.Update(int64(len(a.queue)))
+
+	select {
+	case a.queue <- cb:
+		atomic.AddUint64(&a.enqueued, 1)
+		return
+	default:
+	}
+
+	switch a.options.DropPolicy {
+	case Block:
+		select {
+		case a.queue <- cb:
+			atomic.AddUint64(&a.enqueued, 1)
+		case <-a.closed:
+			a.metrics.dropped.Inc(1)
+		}
+	case DropNewest:
+		a.metrics.dropped.Inc(1)
+	default: // DropOldest
+		select {
+		case <-a.queue:
+			// The evicted callback never runs; count it resolved so it
+			// doesn't permanently hold Flush's drain target hostage.
+			a.metrics.dropped.Inc(1)
+			atomic.AddUint64(&a.resolved, 1)
+		default:
+		}
+		select {
+		case a.queue <- cb:
+			atomic.AddUint64(&a.enqueued, 1)
+		default:
+			a.metrics.dropped.Inc(1)
+		}
+	}
+}
+
+// OnStartSpan implements ContribObserver. The delegate's OnStartSpan itself
+// runs synchronously (it decides whether the span is observed at all and
+// must return promptly), but the returned span observer's callbacks are
+// dispatched asynchronously.
+func (a *AsyncObserver) OnStartSpan(
+	sp opentracing.Span,
+	operationName string,
+	options opentracing.StartSpanOptions,
+) (ContribSpanObserver, bool) {
+	spanObs, ok := a.delegate.OnStartSpan(sp, operationName, options)
+	if !ok {
+		return nil, false
+	}
+	return &asyncSpanObserver{async: a, delegate: spanObs}, true
+}
+
+// Flush blocks until every callback that was enqueued before it was called
+// has been resolved (run, or evicted by DropOldest), or ctx is done. It
+// does not use an in-band sentinel callback: a sentinel sitting in the
+// queue could itself be evicted by a concurrent DropOldest eviction before
+// a worker ever reaches it, which would make Flush hang until ctx expires
+// despite the real work having drained. Instead Flush snapshots the
+// enqueued/resolved counters, which are updated on every enqueue and
+// resolution (including evictions), and polls until they converge.
+// Call it during tracer shutdown so pending callbacks are not lost.
+func (a *AsyncObserver) Flush(ctx context.Context) error {
+	select {
+	case <-a.closed:
+		return errAsyncObserverClosed
+	default:
+	}
+
+	target := atomic.LoadUint64(&a.enqueued)
+	if atomic.LoadUint64(&a.resolved) >= target {
+		return nil
+	}
+
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-a.closed:
+			return errAsyncObserverClosed
+		case <-ticker.C:
+			if atomic.LoadUint64(&a.resolved) >= target {
+				return nil
+			}
+		}
+	}
+}
+
+// Close stops accepting new callbacks and waits for callbacks already in
+// the queue to drain. It never closes the queue channel itself, so a
+// concurrent enqueue can never panic on a send to a closed channel;
+// callbacks enqueued concurrently with or after Close are simply dropped,
+// since no worker remains to run them.
+func (a *AsyncObserver) Close() {
+	a.closeOnce.Do(func() {
+		close(a.closed)
+	})
+	a.wg.Wait()
+}
+
+// asyncSpanObserver dispatches every ContribSpanObserver callback through
+// its owning AsyncObserver's worker pool.
+type asyncSpanObserver struct {
+	async    *AsyncObserver
+	delegate ContribSpanObserver
+}
+
+func (s *asyncSpanObserver) OnSetOperationName(operationName string) {
+	s.async.enqueue(func() { s.delegate.OnSetOperationName(operationName) })
+}
+
+func (s *asyncSpanObserver) OnSetTag(key string, value interface{}) {
+	s.async.enqueue(func() { s.delegate.OnSetTag(key, value) })
+}
+
+func (s *asyncSpanObserver) OnFinish(options opentracing.FinishOptions) {
+	s.async.enqueue(func() { s.delegate.OnFinish(options) })
+}
+
+func (s *asyncSpanObserver) OnLogFields(fields []log.Field) {
+	if d, ok := s.delegate.(ContribSpanObserverLogFields); ok {
+		s.async.enqueue(func() { d.OnLogFields(fields) })
+	}
+}
+
+func (s *asyncSpanObserver) OnLogKV(alternatingKeys ...interface{}) {
+	if d, ok := s.delegate.(ContribSpanObserverLogKV); ok {
+		s.async.enqueue(func() { d.OnLogKV(alternatingKeys...) })
+	}
+}
+
+func (s *asyncSpanObserver) OnSetBaggageItem(key, value string) {
+	if d, ok := s.delegate.(ContribSpanObserverBaggage); ok {
+		s.async.enqueue(func() { d.OnSetBaggageItem(key, value) })
+	}
+}
+
+func (s *asyncSpanObserver) OnAddReference(refType opentracing.SpanReferenceType, referencedContext SpanContext) {
+	if d, ok := s.delegate.(ContribSpanObserverReference); ok {
+		s.async.enqueue(func() { d.OnAddReference(refType, referencedContext) })
+	}
+}
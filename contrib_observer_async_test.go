@@ -0,0 +1,203 @@
+// Copyright (c) 2019 The Jaeger Authors.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jaeger
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/jaeger-client-go/metrics/metricstest"
+)
+
+// blockingObserver is a ContribObserver whose span observer blocks on
+// OnFinish until released, used to force the AsyncObserver's queue to
+// fill up deterministically.
+type blockingObserver struct {
+	release chan struct{}
+	calls   int32
+}
+
+func (o *blockingObserver) OnStartSpan(
+	sp opentracing.Span,
+	operationName string,
+	options opentracing.StartSpanOptions,
+) (ContribSpanObserver, bool) {
+	return &blockingSpanObserver{obs: o}, true
+}
+
+type blockingSpanObserver struct {
+	obs *blockingObserver
+}
+
+func (o *blockingSpanObserver) OnSetOperationName(operationName string) {}
+func (o *blockingSpanObserver) OnSetTag(key string, value interface{})  {}
+
+func (o *blockingSpanObserver) OnFinish(options opentracing.FinishOptions) {
+	atomic.AddInt32(&o.obs.calls, 1)
+	<-o.obs.release
+}
+
+func newTestAsyncObserver(t *testing.T, policy DropPolicy, queueSize int) (*AsyncObserver, *blockingObserver, *metricstest.Factory) {
+	t.Helper()
+	delegate := &blockingObserver{release: make(chan struct{})}
+	factory := metricstest.NewFactory(0)
+	async := WrapAsync(delegate, AsyncOptions{
+		QueueSize:  queueSize,
+		Workers:    1,
+		DropPolicy: policy,
+		Factory:    factory,
+	}).(*AsyncObserver)
+	return async, delegate, factory
+}
+
+func finishOnce(async *AsyncObserver) {
+	spanObs, _ := async.OnStartSpan(nil, "op", opentracing.StartSpanOptions{})
+	spanObs.OnFinish(opentracing.FinishOptions{})
+}
+
+func TestAsyncObserverDropOldest(t *testing.T) {
+	async, delegate, factory := newTestAsyncObserver(t, DropOldest, 1)
+	defer close(delegate.release)
+
+	// First callback occupies the single worker (blocked in OnFinish).
+	finishOnce(async)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&delegate.calls) == 1 }, time.Second, time.Millisecond)
+
+	// Next two callbacks fill, then overflow, the size-1 queue: DropOldest
+	// discards the first queued one to make room for the second.
+	finishOnce(async)
+	finishOnce(async)
+
+	factory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "observer.queue.dropped", Value: 1},
+	)
+}
+
+func TestAsyncObserverDropNewest(t *testing.T) {
+	async, delegate, factory := newTestAsyncObserver(t, DropNewest, 1)
+	defer close(delegate.release)
+
+	finishOnce(async)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&delegate.calls) == 1 }, time.Second, time.Millisecond)
+
+	finishOnce(async)
+	finishOnce(async)
+
+	factory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "observer.queue.dropped", Value: 1},
+	)
+}
+
+func TestAsyncObserverBlockWaitsForRoom(t *testing.T) {
+	async, delegate, factory := newTestAsyncObserver(t, Block, 1)
+	defer close(delegate.release)
+
+	finishOnce(async)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&delegate.calls) == 1 }, time.Second, time.Millisecond)
+
+	finishOnce(async) // fills the queue
+
+	done := make(chan struct{})
+	go func() {
+		finishOnce(async) // blocks until the queue has room
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Block policy returned before queue had room")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	delegate.release <- struct{}{} // unblock the in-flight callback, draining one slot
+	delegate.release <- struct{}{}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Block policy never unblocked after queue drained")
+	}
+
+	factory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "observer.queue.dropped", Value: 0},
+	)
+}
+
+func TestAsyncObserverFlushDrainsUnderLoad(t *testing.T) {
+	async, delegate, _ := newTestAsyncObserver(t, DropOldest, 1)
+	close(delegate.release) // let every OnFinish return immediately
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			finishOnce(async)
+		}()
+	}
+	wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, async.Flush(ctx))
+}
+
+func TestAsyncObserverCloseDoesNotPanicOnConcurrentFinish(t *testing.T) {
+	async, delegate, _ := newTestAsyncObserver(t, DropOldest, 4)
+	close(delegate.release)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				assert.NotPanics(t, func() { finishOnce(async) })
+			}
+		}
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	async.Close()
+	close(stop)
+	wg.Wait()
+}
+
+func TestAsyncObserverFlushAfterCloseReturnsError(t *testing.T) {
+	async, delegate, _ := newTestAsyncObserver(t, DropOldest, 4)
+	close(delegate.release)
+	async.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, async.Flush(ctx))
+}
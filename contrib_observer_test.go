@@ -0,0 +1,107 @@
+// Copyright (c) 2019 The Jaeger Authors.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jaeger
+
+import (
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// baseOnlySpanObserver implements only the required ContribSpanObserver
+// methods, not any of the optional extensions.
+type baseOnlySpanObserver struct {
+	finishes int
+}
+
+func (o *baseOnlySpanObserver) OnSetOperationName(operationName string) {}
+func (o *baseOnlySpanObserver) OnSetTag(key string, value interface{})  {}
+
+func (o *baseOnlySpanObserver) OnFinish(options opentracing.FinishOptions) {
+	o.finishes++
+}
+
+// fullSpanObserver implements every optional extension so fan-out can be
+// asserted to reach it.
+type fullSpanObserver struct {
+	baseOnlySpanObserver
+	loggedFields []log.Field
+	loggedKV     []interface{}
+	baggageKey   string
+	baggageValue string
+	refType      opentracing.SpanReferenceType
+	refContext   SpanContext
+}
+
+func (o *fullSpanObserver) OnLogFields(fields []log.Field) {
+	o.loggedFields = fields
+}
+
+func (o *fullSpanObserver) OnLogKV(alternatingKeys ...interface{}) {
+	o.loggedKV = alternatingKeys
+}
+
+func (o *fullSpanObserver) OnSetBaggageItem(key, value string) {
+	o.baggageKey, o.baggageValue = key, value
+}
+
+func (o *fullSpanObserver) OnAddReference(refType opentracing.SpanReferenceType, referencedContext SpanContext) {
+	o.refType, o.refContext = refType, referencedContext
+}
+
+func TestCompositeSpanObserverFansOutToExtendedInterfacesOnly(t *testing.T) {
+	base := &baseOnlySpanObserver{}
+	full := &fullSpanObserver{}
+	composite := &compositeSpanObserver{observers: []ContribSpanObserver{base, full}}
+
+	fields := []log.Field{log.String("k", "v")}
+	composite.OnLogFields(fields)
+	assert.Equal(t, fields, full.loggedFields)
+
+	composite.OnLogKV("k", "v")
+	assert.Equal(t, []interface{}{"k", "v"}, full.loggedKV)
+
+	composite.OnSetBaggageItem("key", "value")
+	assert.Equal(t, "key", full.baggageKey)
+	assert.Equal(t, "value", full.baggageValue)
+
+	ctx := SpanContext{traceID: 1, spanID: 2}
+	composite.OnAddReference(opentracing.ChildOfRef, ctx)
+	assert.Equal(t, opentracing.ChildOfRef, full.refType)
+	assert.Equal(t, ctx, full.refContext)
+
+	// base never panics or errors even though it doesn't implement any of
+	// the extended interfaces; it just never observes these calls.
+	composite.OnFinish(opentracing.FinishOptions{})
+	assert.Equal(t, 1, base.finishes)
+	assert.Equal(t, 1, full.finishes)
+}
+
+func TestNoopCompositeSpanObserverExtendedMethods(t *testing.T) {
+	assert.NotPanics(t, func() {
+		noopCompositeSpanObserver.OnLogFields(nil)
+		noopCompositeSpanObserver.OnLogKV()
+		noopCompositeSpanObserver.OnSetBaggageItem("k", "v")
+		noopCompositeSpanObserver.OnAddReference(opentracing.ChildOfRef, SpanContext{})
+	})
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2019 The Jaeger Authors.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package metricsobserver implements a jaeger.ContribObserver that turns
+// finished spans into RED metrics (Request count, Error count, Duration
+// histogram), keyed by operation name plus a configurable set of tag
+// dimensions. Register it the same way as any other ContribObserver, e.g.:
+//
+//	tracer, closer := jaeger.NewTracer(
+//		serviceName,
+//		sampler,
+//		reporter,
+//		jaeger.TracerOptions.ContribObserver(metricsobserver.NewObserver(metricsobserver.Options{
+//			Factory: metricsFactory,
+//		})),
+//	)
+package metricsobserver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+
+	"github.com/uber/jaeger-client-go"
+	"github.com/uber/jaeger-client-go/metrics"
+)
+
+const (
+	otherOperationsPlaceholder = "__other__"
+
+	tagHTTPStatus = "http.status_code"
+)
+
+// DefaultMaxOperations is used if Options.MaxOperations is left at zero.
+const DefaultMaxOperations = 200
+
+// DefaultLatencyBuckets is used if Options.LatencyBuckets is left nil.
+var DefaultLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Options configures an Observer.
+type Options struct {
+	// Factory is the metrics backend the observer reports to. Required.
+	Factory metrics.Factory
+
+	// Tags is the set of span tag names that are used as additional metric
+	// dimensions, alongside the operation name, e.g. "span.kind",
+	// "http.status_code", "peer.service". Tags that are absent on a given
+	// span are reported as an empty string.
+	Tags []string
+
+	// AllowedOperationNames, when non-empty, caps cardinality by reporting
+	// metrics for operation names in this set verbatim, and folding every
+	// other operation name into a single "__other__" bucket. When empty,
+	// operation names are capped at MaxOperations instead.
+	AllowedOperationNames []string
+
+	// MaxOperations bounds the number of distinct operation names tracked
+	// before new ones are folded into the "__other__" bucket. Ignored when
+	// AllowedOperationNames is set. Defaults to DefaultMaxOperations.
+	MaxOperations int
+
+	// LatencyBuckets are the histogram bucket boundaries used for the span
+	// duration histogram. Defaults to DefaultLatencyBuckets.
+	LatencyBuckets []time.Duration
+}
+
+func (o Options) allowedSet() map[string]struct{} {
+	if len(o.AllowedOperationNames) == 0 {
+		return nil
+	}
+	allowed := make(map[string]struct{}, len(o.AllowedOperationNames))
+	for _, name := range o.AllowedOperationNames {
+		allowed[name] = struct{}{}
+	}
+	return allowed
+}
+
+// Observer is a jaeger.ContribObserver that derives RED (Requests, Errors,
+// Duration) metrics from the span lifecycle.
+type Observer struct {
+	options Options
+	allowed map[string]struct{}
+
+	mux      sync.Mutex
+	knownOps map[string]struct{}
+	metrics  map[string]*opMetrics
+}
+
+type opMetrics struct {
+	requestCount metrics.Counter
+	errorCount   metrics.Counter
+	latency      metrics.Timer
+}
+
+// NewObserver creates an Observer that reports RED metrics via
+// options.Factory.
+func NewObserver(options Options) *Observer {
+	if options.MaxOperations <= 0 {
+		options.MaxOperations = DefaultMaxOperations
+	}
+	if len(options.LatencyBuckets) == 0 {
+		options.LatencyBuckets = DefaultLatencyBuckets
+	}
+	return &Observer{
+		options:  options,
+		allowed:  options.allowedSet(),
+		knownOps: make(map[string]struct{}),
+		metrics:  make(map[string]*opMetrics),
+	}
+}
+
+// OnStartSpan implements jaeger.ContribObserver.
+func (o *Observer) OnStartSpan(
+	sp opentracing.Span,
+	operationName string,
+	options opentracing.StartSpanOptions,
+) (jaeger.ContribSpanObserver, bool) {
+	startTime := options.StartTime
+	if startTime.IsZero() {
+		startTime = time.Now()
+	}
+	return &spanObserver{
+		observer:      o,
+		operationName: operationName,
+		startTime:     startTime,
+		tags:          make(map[string]interface{}, len(o.options.Tags)),
+	}, true
+}
+
+// spanObserver tracks the state of a single in-flight span so that RED
+// metrics can be recorded once the span finishes.
+type spanObserver struct {
+	observer      *Observer
+	operationName string
+	startTime     time.Time
+	tags          map[string]interface{}
+}
+
+// OnSetOperationName implements jaeger.ContribSpanObserver.
+func (s *spanObserver) OnSetOperationName(operationName string) {
+	s.operationName = operationName
+}
+
+// OnSetTag implements jaeger.ContribSpanObserver.
+func (s *spanObserver) OnSetTag(key string, value interface{}) {
+	s.tags[key] = value
+}
+
+// OnFinish implements jaeger.ContribSpanObserver.
+func (s *spanObserver) OnFinish(options opentracing.FinishOptions) {
+	m := s.observer.metricsFor(s.operationName, s.tags)
+	m.requestCount.Inc(1)
+	if s.isError() {
+		m.errorCount.Inc(1)
+	}
+	finishTime := options.FinishTime
+	if finishTime.IsZero() {
+		finishTime = time.Now()
+	}
+	m.latency.Record(finishTime.Sub(s.startTime))
+}
+
+func (s *spanObserver) isError() bool {
+	if errTag, ok := s.tags[string(ext.Error)]; ok {
+		if isErr, ok := errTag.(bool); ok && isErr {
+			return true
+		}
+	}
+	if status, ok := s.tags[tagHTTPStatus]; ok {
+		switch v := status.(type) {
+		case int:
+			return v >= 500
+		case uint16:
+			return v >= 500
+		case int64:
+			return v >= 500
+		}
+	}
+	return false
+}
+
+// metricsFor returns the opMetrics bucket for the given operation name and
+// tag dimensions, creating it on first use. Operation names are bounded by
+// the allow-list/MaxOperations cap to keep cardinality in check.
+func (o *Observer) metricsFor(operationName string, tags map[string]interface{}) *opMetrics {
+	name := o.boundedOperationName(operationName)
+
+	dims := make(map[string]string, len(o.options.Tags)+1)
+	dims["operation"] = name
+	key := name
+	for _, tagName := range o.options.Tags {
+		v := ""
+		if tagValue, ok := tags[tagName]; ok {
+			v = stringify(tagValue)
+		}
+		dims[tagName] = v
+		key += "|" + tagName + "=" + v
+	}
+
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if m, ok := o.metrics[key]; ok {
+		return m
+	}
+	m := &opMetrics{
+		requestCount: o.options.Factory.Counter("red.requests", dims),
+		errorCount:   o.options.Factory.Counter("red.errors", dims),
+		latency: o.options.Factory.Timer(metrics.TimerOptions{
+			Name:    "red.latency",
+			Tags:    dims,
+			Buckets: o.options.LatencyBuckets,
+		}),
+	}
+	o.metrics[key] = m
+	return m
+}
+
+// boundedOperationName enforces the allow-list/cardinality cap, returning
+// otherOperationsPlaceholder for operation names that would otherwise blow
+// past the cap.
+func (o *Observer) boundedOperationName(operationName string) string {
+	if o.allowed != nil {
+		if _, ok := o.allowed[operationName]; ok {
+			return operationName
+		}
+		return otherOperationsPlaceholder
+	}
+
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if _, ok := o.knownOps[operationName]; ok {
+		return operationName
+	}
+	if len(o.knownOps) >= o.options.MaxOperations {
+		return otherOperationsPlaceholder
+	}
+	o.knownOps[operationName] = struct{}{}
+	return operationName
+}
+
+func stringify(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case fmt.Stringer:
+		return t.String()
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
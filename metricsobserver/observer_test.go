@@ -0,0 +1,128 @@
+// Copyright (c) 2019 The Jaeger Authors.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metricsobserver
+
+import (
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/stretchr/testify/require"
+
+	"github.com/uber/jaeger-client-go/metrics/metricstest"
+)
+
+func startAndFinish(t *testing.T, obs *Observer, operationName string, tags map[string]interface{}) {
+	t.Helper()
+	spanObs, ok := obs.OnStartSpan(nil, operationName, opentracing.StartSpanOptions{})
+	require.True(t, ok)
+	for k, v := range tags {
+		spanObs.OnSetTag(k, v)
+	}
+	spanObs.OnFinish(opentracing.FinishOptions{})
+}
+
+func TestObserverReportsRequestAndErrorCounts(t *testing.T) {
+	factory := metricstest.NewFactory(0)
+	obs := NewObserver(Options{Factory: factory})
+
+	startAndFinish(t, obs, "get_user", nil)
+	startAndFinish(t, obs, "get_user", map[string]interface{}{string(ext.Error): true})
+
+	factory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "red.requests", Tags: map[string]string{"operation": "get_user"}, Value: 2},
+		metricstest.ExpectedMetric{Name: "red.errors", Tags: map[string]string{"operation": "get_user"}, Value: 1},
+	)
+}
+
+func TestObserverIsErrorAcrossStatusCodeTypes(t *testing.T) {
+	tests := []struct {
+		name string
+		tags map[string]interface{}
+		want int64
+	}{
+		{"error tag true", map[string]interface{}{string(ext.Error): true}, 1},
+		{"error tag false", map[string]interface{}{string(ext.Error): false}, 0},
+		{"int 500", map[string]interface{}{tagHTTPStatus: int(500)}, 1},
+		{"int 404", map[string]interface{}{tagHTTPStatus: int(404)}, 0},
+		{"uint16 503", map[string]interface{}{tagHTTPStatus: uint16(503)}, 1},
+		{"int64 500", map[string]interface{}{tagHTTPStatus: int64(500)}, 1},
+		{"int64 200", map[string]interface{}{tagHTTPStatus: int64(200)}, 0},
+		{"no tags", map[string]interface{}{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory := metricstest.NewFactory(0)
+			obs := NewObserver(Options{Factory: factory})
+			startAndFinish(t, obs, "op", tt.tags)
+
+			factory.AssertCounterMetrics(t,
+				metricstest.ExpectedMetric{Name: "red.errors", Tags: map[string]string{"operation": "op"}, Value: int(tt.want)},
+			)
+		})
+	}
+}
+
+func TestObserverAllowedOperationNamesFoldsUnknownIntoOther(t *testing.T) {
+	factory := metricstest.NewFactory(0)
+	obs := NewObserver(Options{
+		Factory:               factory,
+		AllowedOperationNames: []string{"get_user"},
+	})
+
+	startAndFinish(t, obs, "get_user", nil)
+	startAndFinish(t, obs, "delete_everything", nil)
+
+	factory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "red.requests", Tags: map[string]string{"operation": "get_user"}, Value: 1},
+		metricstest.ExpectedMetric{Name: "red.requests", Tags: map[string]string{"operation": otherOperationsPlaceholder}, Value: 1},
+	)
+}
+
+func TestObserverMaxOperationsCapsCardinality(t *testing.T) {
+	factory := metricstest.NewFactory(0)
+	obs := NewObserver(Options{Factory: factory, MaxOperations: 2})
+
+	startAndFinish(t, obs, "op1", nil)
+	startAndFinish(t, obs, "op2", nil)
+	startAndFinish(t, obs, "op3", nil) // overflow, folded into __other__
+	startAndFinish(t, obs, "op1", nil) // still within the cap, tracked as itself
+
+	factory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "red.requests", Tags: map[string]string{"operation": "op1"}, Value: 2},
+		metricstest.ExpectedMetric{Name: "red.requests", Tags: map[string]string{"operation": "op2"}, Value: 1},
+		metricstest.ExpectedMetric{Name: "red.requests", Tags: map[string]string{"operation": otherOperationsPlaceholder}, Value: 1},
+	)
+}
+
+func TestObserverDimensionsFormatting(t *testing.T) {
+	factory := metricstest.NewFactory(0)
+	obs := NewObserver(Options{Factory: factory, Tags: []string{"peer.service"}})
+
+	startAndFinish(t, obs, "op", map[string]interface{}{"peer.service": "billing"})
+	startAndFinish(t, obs, "op", nil) // missing dimension is reported as an empty string, not omitted
+
+	factory.AssertCounterMetrics(t,
+		metricstest.ExpectedMetric{Name: "red.requests", Tags: map[string]string{"operation": "op", "peer.service": "billing"}, Value: 1},
+		metricstest.ExpectedMetric{Name: "red.requests", Tags: map[string]string{"operation": "op", "peer.service": ""}, Value: 1},
+	)
+}
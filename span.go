@@ -0,0 +1,242 @@
+// Copyright (c) 2017 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package jaeger
+
+import (
+	"sync"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+)
+
+// SpanContext represents the propagated state of a span: its trace/span
+// identity plus any baggage items. It implements opentracing.SpanContext.
+type SpanContext struct {
+	traceID  uint64
+	spanID   uint64
+	parentID uint64
+	baggage  map[string]string
+}
+
+// ForeachBaggageItem implements opentracing.SpanContext.
+func (c SpanContext) ForeachBaggageItem(handler func(k, v string) bool) {
+	for k, v := range c.baggage {
+		if !handler(k, v) {
+			break
+		}
+	}
+}
+
+// TraceID returns the identifier of the trace this context belongs to.
+func (c SpanContext) TraceID() uint64 { return c.traceID }
+
+// SpanID returns this context's span identifier.
+func (c SpanContext) SpanID() uint64 { return c.spanID }
+
+// ParentID returns the identifier of the parent span, or zero for a root
+// span.
+func (c SpanContext) ParentID() uint64 { return c.parentID }
+
+// WithBaggageItem returns a copy of c with key/value set as a baggage item,
+// leaving c itself unmodified.
+func (c SpanContext) WithBaggageItem(key, value string) SpanContext {
+	baggage := make(map[string]string, len(c.baggage)+1)
+	for k, v := range c.baggage {
+		baggage[k] = v
+	}
+	baggage[key] = value
+	c.baggage = baggage
+	return c
+}
+
+// Reference records a causal link (e.g. ChildOf, FollowsFrom) from a span
+// to another span's context.
+type Reference struct {
+	Type    opentracing.SpanReferenceType
+	Context SpanContext
+}
+
+// Span implements opentracing.Span. Every mutation is mirrored to the
+// span's observer so that registered ContribObservers see the full span
+// lifecycle, not just tags and finish.
+type Span struct {
+	sync.RWMutex
+
+	tracer opentracing.Tracer
+
+	context       SpanContext
+	operationName string
+
+	startTime time.Time
+	duration  time.Duration
+
+	tags       map[string]interface{}
+	logs       []opentracing.LogRecord
+	references []Reference
+
+	observer *compositeSpanObserver
+}
+
+// newSpan creates a Span and dispatches OnAddReference for every reference
+// passed in via StartSpanOptions whose context is a SpanContext.
+func newSpan(
+	tracer opentracing.Tracer,
+	operationName string,
+	opts opentracing.StartSpanOptions,
+	context SpanContext,
+	observer *compositeSpanObserver,
+) *Span {
+	if observer == nil {
+		observer = noopCompositeSpanObserver
+	}
+	startTime := opts.StartTime
+	if startTime.IsZero() {
+		startTime = time.Now()
+	}
+	span := &Span{
+		tracer:        tracer,
+		context:       context,
+		operationName: operationName,
+		startTime:     startTime,
+		tags:          make(map[string]interface{}, len(opts.Tags)),
+		observer:      observer,
+	}
+	for k, v := range opts.Tags {
+		span.tags[k] = v
+	}
+	for _, ref := range opts.References {
+		span.addReference(ref)
+	}
+	return span
+}
+
+// addReference records ref on the span and dispatches it to the observer.
+// References whose context was not produced by this package are ignored,
+// since there is nothing meaningful to record or report for them.
+func (s *Span) addReference(ref opentracing.SpanReference) {
+	refCtx, ok := ref.ReferencedContext.(SpanContext)
+	if !ok {
+		return
+	}
+	s.Lock()
+	s.references = append(s.references, Reference{Type: ref.Type, Context: refCtx})
+	s.Unlock()
+	s.observer.OnAddReference(ref.Type, refCtx)
+}
+
+// Context implements opentracing.Span.
+func (s *Span) Context() opentracing.SpanContext {
+	s.RLock()
+	defer s.RUnlock()
+	return s.context
+}
+
+// SetOperationName implements opentracing.Span.
+func (s *Span) SetOperationName(operationName string) opentracing.Span {
+	s.Lock()
+	s.operationName = operationName
+	s.Unlock()
+	s.observer.OnSetOperationName(operationName)
+	return s
+}
+
+// SetTag implements opentracing.Span.
+func (s *Span) SetTag(key string, value interface{}) opentracing.Span {
+	s.Lock()
+	s.tags[key] = value
+	s.Unlock()
+	s.observer.OnSetTag(key, value)
+	return s
+}
+
+// LogFields implements opentracing.Span.
+func (s *Span) LogFields(fields ...log.Field) {
+	s.Lock()
+	s.logs = append(s.logs, opentracing.LogRecord{Timestamp: time.Now(), Fields: fields})
+	s.Unlock()
+	s.observer.OnLogFields(fields)
+}
+
+// LogKV implements opentracing.Span.
+func (s *Span) LogKV(alternatingKeys ...interface{}) {
+	fields, err := log.InterleavedKVToFields(alternatingKeys...)
+	if err != nil {
+		s.LogFields(log.Error(err))
+		return
+	}
+	s.Lock()
+	s.logs = append(s.logs, opentracing.LogRecord{Timestamp: time.Now(), Fields: fields})
+	s.Unlock()
+	s.observer.OnLogKV(alternatingKeys...)
+}
+
+// LogEvent implements opentracing.Span.
+func (s *Span) LogEvent(event string) {
+	s.LogKV("event", event)
+}
+
+// LogEventWithPayload implements opentracing.Span.
+func (s *Span) LogEventWithPayload(event string, payload interface{}) {
+	s.LogKV("event", event, "payload", payload)
+}
+
+// Log implements opentracing.Span.
+func (s *Span) Log(data opentracing.LogData) {
+	s.LogEventWithPayload(data.Event, data.Payload)
+}
+
+// SetBaggageItem implements opentracing.Span.
+func (s *Span) SetBaggageItem(restrictedKey, value string) opentracing.Span {
+	s.Lock()
+	s.context = s.context.WithBaggageItem(restrictedKey, value)
+	s.Unlock()
+	s.observer.OnSetBaggageItem(restrictedKey, value)
+	return s
+}
+
+// BaggageItem implements opentracing.Span.
+func (s *Span) BaggageItem(restrictedKey string) string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.context.baggage[restrictedKey]
+}
+
+// Tracer implements opentracing.Span.
+func (s *Span) Tracer() opentracing.Tracer {
+	return s.tracer
+}
+
+// Finish implements opentracing.Span.
+func (s *Span) Finish() {
+	s.FinishWithOptions(opentracing.FinishOptions{FinishTime: time.Now()})
+}
+
+// FinishWithOptions implements opentracing.Span.
+func (s *Span) FinishWithOptions(options opentracing.FinishOptions) {
+	if options.FinishTime.IsZero() {
+		options.FinishTime = time.Now()
+	}
+	s.Lock()
+	s.duration = options.FinishTime.Sub(s.startTime)
+	s.Unlock()
+	s.observer.OnFinish(options)
+}